@@ -0,0 +1,108 @@
+// Command iso9660unwrap extracts a file, or an entire tree, from an
+// ISO9660 image.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/johto/iso9660wrap/iso9660"
+)
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [--file=NAME] IMAGE OUTPATH\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       without --file, the whole image is extracted as a tree rooted at OUTPATH\n")
+	fmt.Fprintf(os.Stderr, "       with --file, only NAME (as it appears in the image) is extracted, to OUTPATH\n")
+}
+
+func main() {
+	flagSet := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flagSet.Usage = printUsage
+	file := flagSet.String("file", "", "extract only this file instead of the whole tree")
+	if err := flagSet.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+	if flagSet.NArg() != 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	log.SetFlags(0)
+
+	image := flagSet.Arg(0)
+	outpath := flagSet.Arg(1)
+
+	imgfh, err := os.Open(image)
+	if err != nil {
+		log.Fatalf("could not open image %s: %s", image, err)
+	}
+
+	r, err := iso9660.NewReader(imgfh)
+	if err != nil {
+		log.Fatalf("could not read image %s: %s", image, err)
+	}
+
+	if *file != "" {
+		err = extractFile(r, *file, outpath)
+	} else {
+		err = extractTree(r, outpath)
+	}
+	if err != nil {
+		log.Fatalf("could not extract from image %s: %s", image, err)
+	}
+}
+
+// extractFile copies the single file named name, as it appears in the
+// image, to outpath.
+func extractFile(r *iso9660.Reader, name string, outpath string) error {
+	src, err := r.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(outpath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// extractTree recreates the image's entire directory tree under outpath.
+func extractTree(r *iso9660.Reader, outpath string) error {
+	return fs.WalkDir(r, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(outpath, filepath.FromSlash(name))
+		if d.IsDir() {
+			return os.MkdirAll(dst, 0777)
+		}
+
+		src, err := r.Open(name)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		outfh, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		defer outfh.Close()
+
+		_, err = io.Copy(outfh, src)
+		return err
+	})
+}
@@ -0,0 +1,224 @@
+// Command iso9660wrap wraps a file, or a directory tree, in a minimal
+// ISO9660 image.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/johto/iso9660wrap/iso9660"
+)
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [--joliet=true|false] [--boot-image=FILE] [--boot-emulation=none|1200|1440|2880] [--name=NAME] INFILE OUTFILE\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       INFILE may be \"-\" to read a single file from standard input; --name is then required\n")
+}
+
+func main() {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.Usage = printUsage
+	joliet := fs.Bool("joliet", true, "emit a Joliet supplementary volume descriptor for long/Unicode names")
+	bootImage := fs.String("boot-image", "", "make the image bootable (El Torito) using this file as the boot image")
+	bootEmulation := fs.String("boot-emulation", "none", "boot emulation type: none, 1200, 1440, or 2880")
+	name := fs.String("name", "", "file identifier to use when INFILE is \"-\" (standard input)")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+	if fs.NArg() != 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	log.SetFlags(0)
+
+	infile := fs.Arg(0)
+	outfile := fs.Arg(1)
+
+	outfh, err := os.OpenFile(outfile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		log.Fatalf("could not open output file %s for writing: %s", outfile, err)
+	}
+
+	var b *iso9660.Builder
+	if infile == "-" {
+		if *name == "" {
+			log.Fatal("--name is required when reading from standard input")
+		}
+		b, err = builderForStdin(*name)
+	} else {
+		var infi os.FileInfo
+		infi, err = os.Stat(infile)
+		if err != nil {
+			log.Fatalf("could not read from input file %s: %s", infile, err)
+		}
+		if infi.IsDir() {
+			b, err = builderForDirectory(infile)
+		} else {
+			b, err = builderForFile(infile, infi)
+		}
+	}
+	if err != nil {
+		log.Fatalf("could not read from input file %s: %s", infile, err)
+	}
+	b.SetJoliet(*joliet)
+
+	if *bootImage != "" {
+		emulation, err := parseBootEmulation(*bootEmulation)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bootfh, bootSize, err := openBootImage(*bootImage)
+		if err != nil {
+			log.Fatalf("could not read boot image %s: %s", *bootImage, err)
+		}
+		if err := b.SetBootImage(bootfh, bootSize, iso9660.BootOptions{EmulationType: emulation}); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := b.Build(outfh); err != nil {
+		log.Fatalf("could not write to output file: %s", err)
+	}
+}
+
+func parseBootEmulation(s string) (iso9660.EmulationType, error) {
+	switch s {
+	case "none":
+		return iso9660.NoEmulation, nil
+	case "1200":
+		return iso9660.Floppy1200KBEmulation, nil
+	case "1440":
+		return iso9660.Floppy1440KBEmulation, nil
+	case "2880":
+		return iso9660.Floppy2880KBEmulation, nil
+	default:
+		return 0, fmt.Errorf("unknown --boot-emulation value %q", s)
+	}
+}
+
+// openBootImage opens the boot image at path. Unlike the files added to the
+// volume's directory hierarchy, a boot image is not split into multiple
+// extents, so its size must fit in a uint32.
+func openBootImage(path string) (*os.File, uint32, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if fi.Size() >= math.MaxUint32 {
+		return nil, 0, fmt.Errorf("boot image size %d is too large", fi.Size())
+	}
+	return fh, uint32(fi.Size()), nil
+}
+
+// builderForStdin spills standard input to a temporary file, so that its
+// size is known up front the way every other input's size is, then adds it
+// to a new Builder under name. The temporary file is removed from its
+// directory when builderForStdin returns, before the image is built from
+// it; the underlying storage is freed once the process exits.
+func builderForStdin(name string) (*iso9660.Builder, error) {
+	tmp, err := os.CreateTemp("", "iso9660wrap")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	b := iso9660.NewBuilder()
+	if err := b.Add(name, tmp, uint64(size), iso9660.Attributes{}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func builderForFile(infile string, infi os.FileInfo) (*iso9660.Builder, error) {
+	infh, err := os.Open(infile)
+	if err != nil {
+		return nil, err
+	}
+	size := fileSize(infi)
+
+	b := iso9660.NewBuilder()
+	if err := b.Add(infi.Name(), infh, size, attributesFromFileInfo(infi)); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func builderForDirectory(dir string) (*iso9660.Builder, error) {
+	b := iso9660.NewBuilder()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if path == dir {
+				b.SetRootAttributes(attributesFromFileInfo(fi))
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			return b.SetDirAttributes(filepath.ToSlash(rel), attributesFromFileInfo(fi))
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fh, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		return b.Add(filepath.ToSlash(rel), fh, fileSize(fi), attributesFromFileInfo(fi))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// fileSize returns fi's size for use with Builder.Add, which splits files
+// of any size across as many extents as necessary.
+func fileSize(fi os.FileInfo) uint64 {
+	return uint64(fi.Size())
+}
+
+// attributesFromFileInfo derives the Rock Ridge attributes to record for
+// fi from the result of os.Stat, including uid/gid and access/change
+// times where the platform's os.FileInfo.Sys() exposes them.
+func attributesFromFileInfo(fi os.FileInfo) iso9660.Attributes {
+	attrs := iso9660.Attributes{
+		Mode:       fi.Mode(),
+		ModTime:    fi.ModTime(),
+		AccessTime: fi.ModTime(),
+		ChangeTime: fi.ModTime(),
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		attrs.UID = st.Uid
+		attrs.GID = st.Gid
+		attrs.AccessTime = time.Unix(st.Atim.Sec, st.Atim.Nsec)
+		attrs.ChangeTime = time.Unix(st.Ctim.Sec, st.Ctim.Nsec)
+	}
+	return attrs
+}
@@ -0,0 +1,673 @@
+package iso9660
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Builder assembles a tree of files and directories into an ISO9660 image.
+// The zero value is not usable; create one with NewBuilder.
+type Builder struct {
+	root   *dirNode
+	joliet bool
+	boot   *bootImage
+}
+
+type dirNode struct {
+	name       string // ISO9660 identifier; empty for the root directory
+	jolietName string // Joliet (UCS-2) identifier; empty for the root directory
+	rrName     string // original, unsanitized name; empty for the root directory
+	attrs      Attributes
+	parent     *dirNode
+	dirs       []*dirNode
+	files      []*fileNode
+
+	recno      uint16
+	extentLBA  uint32
+	numSectors uint32
+
+	jolietExtentLBA  uint32
+	jolietNumSectors uint32
+
+	rr     *rockRidgeLayout // referenced by this directory's record in its parent; carries NM
+	rrSelf *rockRidgeLayout // this directory's own "." self-record; no NM, per RRIP
+}
+
+type fileNode struct {
+	name       string
+	jolietName string
+	rrName     string
+	attrs      Attributes
+	size       uint64
+	r          io.Reader
+
+	// extents holds one entry per directory record this file is split
+	// into; a file larger than maxExtentBytes needs more than one, per
+	// the ISO9660 multi-extent mechanism (ECMA-119 6.4.3).
+	extents []fileExtent
+
+	rr *rockRidgeLayout
+}
+
+// fileExtent is one contiguous piece of a (possibly multi-extent) file.
+type fileExtent struct {
+	lba  uint32
+	size uint32
+}
+
+// maxExtentBytes is the largest size, in bytes, a single directory
+// record's extent can describe: the largest uint32 that's also a whole
+// number of sectors, since both-endian dataLength fields are 32 bits wide
+// and a non-final extent must not split a sector.
+const maxExtentBytes uint64 = math.MaxUint32 - (math.MaxUint32 % uint64(SectorSize))
+
+// assignFileExtents splits f's data across one or more extents of at most
+// maxExtentBytes each, starting at *cursor, and advances *cursor past them.
+func assignFileExtents(f *fileNode, cursor *uint32) {
+	remaining := f.size
+	for {
+		n := remaining
+		if n > maxExtentBytes {
+			n = maxExtentBytes
+		}
+		f.extents = append(f.extents, fileExtent{lba: *cursor, size: uint32(n)})
+		*cursor += numSectorsForSize(uint32(n))
+		remaining -= n
+		if remaining == 0 {
+			break
+		}
+	}
+}
+
+// jolietMaxNameLength is the longest file identifier, in UCS-2 code units,
+// the Joliet specification allows.
+const jolietMaxNameLength = 64
+
+// NewBuilder returns an empty Builder with Joliet support enabled.
+func NewBuilder() *Builder {
+	return &Builder{root: &dirNode{}, joliet: true}
+}
+
+// SetJoliet enables or disables the Joliet supplementary volume descriptor.
+// It is enabled by default.
+func (b *Builder) SetJoliet(enabled bool) {
+	b.joliet = enabled
+}
+
+// Add adds a file to the image at path, creating any intermediate
+// directories that don't already exist. Each path component is uppercased
+// and sanitized to the ISO9660 d-character set, with any other character
+// replaced by "_"; the original (non-uppercased) component is used as the
+// corresponding Joliet name, truncated to jolietMaxNameLength UCS-2 code
+// units, and (in full, untruncated) as the Rock Ridge alternate (NM) name.
+// size bytes are read from r when Build is called; size may exceed 4 GiB,
+// in which case the file is recorded as multiple directory records (one
+// per extent), per the ISO9660 multi-extent mechanism. attrs is recorded
+// as the file's Rock Ridge POSIX attributes; any intermediate directory
+// created along the way gets the zero Attributes value, which can be
+// overridden afterwards with SetDirAttributes.
+func (b *Builder) Add(path string, r io.Reader, size uint64, attrs Attributes) error {
+	parts := splitPath(path)
+	if len(parts) == 0 {
+		return fmt.Errorf("iso9660: empty path")
+	}
+
+	dir, err := b.mkdirAll(parts[:len(parts)-1])
+	if err != nil {
+		return err
+	}
+
+	part := parts[len(parts)-1]
+	name, err := isoName(part)
+	if err != nil {
+		return err
+	}
+	dir.files = append(dir.files, &fileNode{
+		name:       name,
+		jolietName: jolietName(part),
+		rrName:     part,
+		attrs:      attrs,
+		size:       size,
+		r:          r,
+	})
+	return nil
+}
+
+// SetRootAttributes sets the root directory's Rock Ridge POSIX attributes.
+func (b *Builder) SetRootAttributes(attrs Attributes) {
+	b.root.attrs = attrs
+}
+
+// SetDirAttributes sets the Rock Ridge POSIX attributes of the directory
+// at path, creating it (and any missing intermediate directories) if it
+// doesn't already exist.
+func (b *Builder) SetDirAttributes(path string, attrs Attributes) error {
+	dir, err := b.mkdirAll(splitPath(path))
+	if err != nil {
+		return err
+	}
+	dir.attrs = attrs
+	return nil
+}
+
+// mkdirAll walks parts from the root, creating any directory that doesn't
+// already exist, and returns the final one.
+func (b *Builder) mkdirAll(parts []string) (*dirNode, error) {
+	dir := b.root
+	for _, part := range parts {
+		name, err := isoName(part)
+		if err != nil {
+			return nil, err
+		}
+		dir, err = dir.subdir(name, jolietName(part), part)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dir, nil
+}
+
+// isoName derives the primary (non-Joliet) identifier for a path component.
+// Real-world names routinely contain characters ISO9660 doesn't allow
+// (lowercase letters, dots, dashes, ...); rather than rejecting the file
+// outright, those characters are replaced with underscores. The original
+// name survives in the Joliet hierarchy, when enabled.
+func isoName(part string) (string, error) {
+	if part == "" {
+		return "", fmt.Errorf("iso9660: empty path component")
+	}
+	name := strings.Map(func(r rune) rune {
+		r = unicode.ToUpper(r)
+		if strings.ContainsRune(isoNameCharset, r) {
+			return r
+		}
+		return '_'
+	}, part)
+	if len(name) > isoNameMaxLength {
+		name = name[:isoNameMaxLength]
+	}
+	return name, nil
+}
+
+const isoNameCharset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_"
+
+// isoNameMaxLength is the longest a (level 2) ISO9660 file or directory
+// identifier may be.
+const isoNameMaxLength = 31
+
+func jolietName(part string) string {
+	units := 0
+	for i, r := range part {
+		n := 1
+		if r > 0xFFFF {
+			n = 2 // encoded as a UTF-16 surrogate pair
+		}
+		if units+n > jolietMaxNameLength {
+			return part[:i]
+		}
+		units += n
+	}
+	return part
+}
+
+func splitPath(path string) []string {
+	var parts []string
+	for _, part := range strings.Split(path, "/") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// subdir returns d's child directory for the path component that produced
+// name/joliet/rrName, creating it if this is the first time that original
+// component (rrName) has been seen. Matching on rrName, rather than name,
+// keeps two distinct original names that happen to sanitize to the same
+// ISO9660 identifier (e.g. "My-Dir" and "my_dir", both "MY_DIR") from being
+// silently merged into one directory and losing one side's Joliet/Rock
+// Ridge name; instead it's reported as an error.
+func (d *dirNode) subdir(name string, joliet string, rrName string) (*dirNode, error) {
+	for _, c := range d.dirs {
+		if c.rrName == rrName {
+			return c, nil
+		}
+		if c.name == name {
+			return nil, fmt.Errorf("iso9660: directory names %q and %q both sanitize to the ISO9660 identifier %q", c.rrName, rrName, name)
+		}
+	}
+	c := &dirNode{name: name, jolietName: joliet, rrName: rrName, parent: d}
+	d.dirs = append(d.dirs, c)
+	return c, nil
+}
+
+// Build writes the assembled image to dst.
+func (b *Builder) Build(dst io.Writer) error {
+	return withPanicRecovery(func() {
+		b.build(dst)
+	})
+}
+
+func (b *Builder) build(dst io.Writer) {
+	dirs := layOutDirectoryHierarchy(b.root)
+	files := collectFiles(dirs)
+
+	var ceBlocks []*continuationBlock
+	computeRockRidgeLayouts(dirs, files, &ceBlocks)
+
+	primary := sizeHierarchy(dirs, false, ASCIIEncoding)
+
+	// El Torito requires the Boot Record Volume Descriptor to immediately
+	// follow the Primary Volume Descriptor, so it comes before the Joliet
+	// Supplementary Volume Descriptor regardless of write order below.
+	brvdSector := primaryVolumeSectorNum + 1
+	svdSector := brvdSector
+	if b.boot != nil {
+		svdSector++
+	}
+	terminatorSector := svdSector
+	if b.joliet {
+		terminatorSector++
+	}
+	cursor := terminatorSector + 1 // first sector after the descriptor set
+
+	primary.leSectorNum = cursor
+	cursor += 2 * primary.pathTableSectors
+	assignExtents(dirs, false, cursor)
+	for _, d := range dirs {
+		cursor += d.numSectors
+	}
+
+	for _, cb := range ceBlocks {
+		cb.extentLBA = cursor
+		cb.numSectors = numSectorsForSize(uint32(len(cb.data)))
+		cursor += cb.numSectors
+	}
+
+	if b.boot != nil {
+		b.boot.catalogLBA = cursor
+		cursor++
+	}
+
+	var joliet hierarchySizes
+	if b.joliet {
+		joliet = sizeHierarchy(dirs, true, UCS2Encoding)
+		joliet.leSectorNum = cursor
+		cursor += 2 * joliet.pathTableSectors
+		assignExtents(dirs, true, cursor)
+		for _, d := range dirs {
+			cursor += d.jolietNumSectors
+		}
+	}
+
+	if b.boot != nil {
+		b.boot.extentLBA = cursor
+		b.boot.numSectors = numSectorsForSize(b.boot.size)
+		cursor += b.boot.numSectors
+	}
+
+	for _, f := range files {
+		assignFileExtents(f, &cursor)
+	}
+
+	bufw := bufio.NewWriter(dst)
+	w := NewISO9660Writer(bufw)
+
+	root := dirs[0]
+	writePrimaryVolumeDescriptor(w, primaryVolumeSectorNum, volumeDescriptorParams{
+		totalSectors:     cursor,
+		pathTableBytes:   primary.pathTableBytes,
+		pathTableSectors: primary.pathTableSectors,
+		leSectorNum:      primary.leSectorNum,
+		rootLBA:          root.extentLBA,
+		rootBytes:        root.numSectors * SectorSize,
+	})
+	if b.boot != nil {
+		writeBootRecordVolumeDescriptor(w, brvdSector, b.boot.catalogLBA)
+	}
+	if b.joliet {
+		writeSupplementaryVolumeDescriptor(w, svdSector, volumeDescriptorParams{
+			totalSectors:     cursor,
+			pathTableBytes:   joliet.pathTableBytes,
+			pathTableSectors: joliet.pathTableSectors,
+			leSectorNum:      joliet.leSectorNum,
+			rootLBA:          root.jolietExtentLBA,
+			rootBytes:        root.jolietNumSectors * SectorSize,
+		})
+	}
+	writeVolumeDescriptorSetTerminator(w, terminatorSector)
+
+	writePathTable(w, dirs, false, ASCIIEncoding, binary.LittleEndian)
+	writePathTable(w, dirs, false, ASCIIEncoding, binary.BigEndian)
+	for _, d := range dirs {
+		writeDirectory(w, d, false, ASCIIEncoding)
+	}
+	for _, cb := range ceBlocks {
+		writeContinuationBlock(w, cb)
+	}
+	if b.boot != nil {
+		writeBootCatalog(w, b.boot)
+	}
+	if b.joliet {
+		writePathTable(w, dirs, true, UCS2Encoding, binary.LittleEndian)
+		writePathTable(w, dirs, true, UCS2Encoding, binary.BigEndian)
+		for _, d := range dirs {
+			writeDirectory(w, d, true, UCS2Encoding)
+		}
+	}
+	if b.boot != nil {
+		writeBootImageData(w, b.boot)
+	}
+	for _, f := range files {
+		writeFileData(w, f)
+	}
+
+	w.Finish()
+
+	if err := bufw.Flush(); err != nil {
+		panic(err)
+	}
+}
+
+// layOutDirectoryHierarchy assigns path table record numbers to every
+// directory in the tree and returns them in path table order: a directory
+// always precedes its children, and siblings are sorted by name. The same
+// order and record numbers are shared by the primary and Joliet path
+// tables, since both describe the same directory shape.
+func layOutDirectoryHierarchy(root *dirNode) []*dirNode {
+	order := []*dirNode{root}
+	queue := []*dirNode{root}
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+
+		sort.Slice(d.dirs, func(i, j int) bool { return d.dirs[i].name < d.dirs[j].name })
+		sort.Slice(d.files, func(i, j int) bool { return d.files[i].name < d.files[j].name })
+
+		for _, c := range d.dirs {
+			order = append(order, c)
+			queue = append(queue, c)
+		}
+	}
+	for i, d := range order {
+		d.recno = uint16(i + 1)
+	}
+	return order
+}
+
+func collectFiles(dirs []*dirNode) []*fileNode {
+	var files []*fileNode
+	for _, d := range dirs {
+		files = append(files, d.files...)
+	}
+	return files
+}
+
+func dirIdentifier(d *dirNode, joliet bool) string {
+	if d.parent == nil {
+		return "\x00" // root directory identifier
+	}
+	if joliet {
+		return d.jolietName
+	}
+	return d.name
+}
+
+func fileIdentifier(f *fileNode, joliet bool) string {
+	if joliet {
+		return f.jolietName
+	}
+	return f.name
+}
+
+// hierarchySizes holds the path table geometry for one (primary or Joliet)
+// directory hierarchy.
+type hierarchySizes struct {
+	pathTableBytes   uint32
+	pathTableSectors uint32
+	leSectorNum      uint32
+}
+
+// sizeHierarchy computes each directory's on-disk size (in sectors) and the
+// path table's size, for either the primary or the Joliet hierarchy.
+func sizeHierarchy(dirs []*dirNode, joliet bool, enc Encoding) hierarchySizes {
+	entryLens := make([]int, len(dirs))
+	total := 0
+	for i, d := range dirs {
+		l := pathTableEntryLength(dirIdentifier(d, joliet), enc)
+		entryLens[i] = l
+		total += l
+	}
+
+	for _, d := range dirs {
+		recordLens := directoryRecordLengths(d, joliet, enc)
+		if joliet {
+			d.jolietNumSectors = packedSectorCount(recordLens)
+		} else {
+			d.numSectors = packedSectorCount(recordLens)
+		}
+	}
+
+	return hierarchySizes{
+		pathTableBytes:   uint32(total),
+		pathTableSectors: packedSectorCount(entryLens),
+	}
+}
+
+func assignExtents(dirs []*dirNode, joliet bool, startLBA uint32) {
+	cursor := startLBA
+	for _, d := range dirs {
+		if joliet {
+			d.jolietExtentLBA = cursor
+			cursor += d.jolietNumSectors
+		} else {
+			d.extentLBA = cursor
+			cursor += d.numSectors
+		}
+	}
+}
+
+func pathTableEntryLength(identifier string, enc Encoding) int {
+	idLen := len(identifierBytes(identifier, enc))
+	n := 8 + idLen
+	if idLen%2 != 0 {
+		n++
+	}
+	return n
+}
+
+func writePathTable(w *ISO9660Writer, dirs []*dirNode, joliet bool, enc Encoding, bo binary.ByteOrder) {
+	sw := w.NextSector()
+	for _, d := range dirs {
+		identifier := dirIdentifier(d, joliet)
+		idBytes := identifierBytes(identifier, enc)
+		entryLen := pathTableEntryLength(identifier, enc)
+		if sw.remaining() < uint32(entryLen) {
+			sw = w.NextSector()
+		}
+
+		parentRecno := uint16(1)
+		if d.parent != nil {
+			parentRecno = d.parent.recno
+		}
+		extentLBA := d.extentLBA
+		if joliet {
+			extentLBA = d.jolietExtentLBA
+		}
+
+		sw.WriteByte(byte(len(idBytes)))
+		sw.WriteByte(0) // number of sectors in extended attribute record
+		sw.WriteDWord(bo, extentLBA)
+		sw.WriteWord(bo, parentRecno)
+		sw.write(idBytes)
+		if len(idBytes)%2 != 0 {
+			sw.WriteByte(0) // padding
+		}
+	}
+}
+
+func numSectorsForSize(size uint32) uint32 {
+	n := size / SectorSize
+	if size%SectorSize != 0 || n == 0 {
+		n++
+	}
+	return n
+}
+
+// computeRockRidgeLayouts builds the Rock Ridge system use area for every
+// directory and file entry in the primary hierarchy, splitting any that
+// overflow a single directory record's length limit into a continuation
+// area entry appended to *blocks. Rock Ridge is only ever emitted on the
+// primary (non-Joliet) hierarchy.
+//
+// Each directory gets two layouts: d.rr, used for the record naming it in
+// its parent (carries an NM entry), and d.rrSelf, used for its own "."
+// self-record (no NM; per RRIP, "." and ".." records must not carry one).
+func computeRockRidgeLayouts(dirs []*dirNode, files []*fileNode, blocks *[]*continuationBlock) {
+	for _, d := range dirs {
+		isRoot := d.parent == nil
+
+		d.rr = buildRockRidgeLayout(d.attrs, true, false, d.rrName)
+		d.rr.split(len(identifierBytes(dirIdentifier(d, false), ASCIIEncoding)), blocks)
+
+		d.rrSelf = buildRockRidgeLayout(d.attrs, true, isRoot, "")
+		d.rrSelf.split(len(identifierBytes("\x00", ASCIIEncoding)), blocks)
+	}
+	for _, f := range files {
+		f.rr = buildRockRidgeLayout(f.attrs, false, false, f.rrName)
+		f.rr.split(len(identifierBytes(fileIdentifier(f, false), ASCIIEncoding)), blocks)
+	}
+}
+
+// rockRidgeSUSP returns d's (or f's, via the caller) system use area, or
+// nil outside the primary hierarchy or before it has been computed.
+func rockRidgeSUSP(rr *rockRidgeLayout, joliet bool) []byte {
+	if joliet || rr == nil {
+		return nil
+	}
+	return rr.bytes()
+}
+
+func directoryRecordLengths(d *dirNode, joliet bool, enc Encoding) []int {
+	lens := []int{
+		RecordLength("\x00", enc, rockRidgeSUSP(d.rrSelf, joliet)),
+		RecordLength("\x01", enc, nil),
+	}
+	for _, c := range d.dirs {
+		lens = append(lens, RecordLength(dirIdentifier(c, joliet), enc, rockRidgeSUSP(c.rr, joliet)))
+	}
+	for _, f := range d.files {
+		identifier := fileIdentifier(f, joliet)
+		susp := rockRidgeSUSP(f.rr, joliet)
+		// f.extents is only assigned once the directories have been sized,
+		// so count the extents assignFileExtents will give f.size here.
+		n := 1
+		if f.size > maxExtentBytes {
+			n = int((f.size-1)/maxExtentBytes) + 1
+		}
+		for i := 0; i < n; i++ {
+			lens = append(lens, RecordLength(identifier, enc, lastExtentSUSP(susp, i, n)))
+		}
+	}
+	return lens
+}
+
+// lastExtentSUSP returns susp for the final extent of a (possibly
+// multi-extent) file's directory records and nil for the rest: per RRIP,
+// Rock Ridge entries are only recorded on a multi-extent file's last
+// directory record.
+func lastExtentSUSP(susp []byte, i, n int) []byte {
+	if i != n-1 {
+		return nil
+	}
+	return susp
+}
+
+func writeDirectory(w *ISO9660Writer, d *dirNode, joliet bool, enc Encoding) {
+	extentLBA, numSectors := d.extentLBA, d.numSectors
+	if joliet {
+		extentLBA, numSectors = d.jolietExtentLBA, d.jolietNumSectors
+	}
+
+	sw := w.NextSector()
+	if w.CurrentSector() != extentLBA {
+		panicf("internal error: unexpected directory sector %d (expected %d)", w.CurrentSector(), extentLBA)
+	}
+
+	parent := d.parent
+	if parent == nil {
+		parent = d
+	}
+	parentLBA, parentNumSectors := parent.extentLBA, parent.numSectors
+	if joliet {
+		parentLBA, parentNumSectors = parent.jolietExtentLBA, parent.jolietNumSectors
+	}
+
+	emit := func(identifier string, entryLBA uint32, dataLength uint32, susp []byte) {
+		if sw.remaining() < uint32(RecordLength(identifier, enc, susp)) {
+			sw = w.NextSector()
+		}
+		WriteDirectoryRecord(sw, identifier, entryLBA, dataLength, enc, susp)
+	}
+
+	emit("\x00", extentLBA, numSectors*SectorSize, rockRidgeSUSP(d.rrSelf, joliet))
+	emit("\x01", parentLBA, parentNumSectors*SectorSize, nil)
+	for _, c := range d.dirs {
+		cLBA, cSectors := c.extentLBA, c.numSectors
+		if joliet {
+			cLBA, cSectors = c.jolietExtentLBA, c.jolietNumSectors
+		}
+		emit(dirIdentifier(c, joliet), cLBA, cSectors*SectorSize, rockRidgeSUSP(c.rr, joliet))
+	}
+	for _, f := range d.files {
+		identifier := fileIdentifier(f, joliet)
+		fileSUSP := rockRidgeSUSP(f.rr, joliet)
+		for i, e := range f.extents {
+			susp := lastExtentSUSP(fileSUSP, i, len(f.extents))
+			if sw.remaining() < uint32(RecordLength(identifier, enc, susp)) {
+				sw = w.NextSector()
+			}
+			notFinal := i != len(f.extents)-1
+			WriteFileRecordHeader(sw, identifier, e.lba, e.size, notFinal, enc, susp)
+		}
+	}
+}
+
+// writeContinuationBlock writes a Rock Ridge continuation area extent. Its
+// contents (a single NM entry) are always well under SectorSize.
+func writeContinuationBlock(w *ISO9660Writer, cb *continuationBlock) {
+	sw := w.NextSector()
+	if w.CurrentSector() != cb.extentLBA {
+		panicf("internal error: unexpected continuation area sector %d (expected %d)", w.CurrentSector(), cb.extentLBA)
+	}
+	sw.Write(cb.data)
+}
+
+func writeFileData(w *ISO9660Writer, f *fileNode) {
+	b := make([]byte, SectorSize)
+	for _, e := range f.extents {
+		total := uint32(0)
+		for total < e.size {
+			n := e.size - total
+			if n > SectorSize {
+				n = SectorSize
+			}
+			sw := w.NextSector()
+			if _, err := io.ReadFull(f.r, b[:n]); err != nil {
+				panicf("could not read from input file %s: %s", f.name, err)
+			}
+			sw.Write(b[:n])
+			total += n
+		}
+		if e.size == 0 {
+			// Even a zero-length extent occupies its reserved sector.
+			w.NextSector()
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// elToritoBootSystemID is the Boot Record Volume Descriptor's boot system
+// identifier for an El Torito bootable image, followed by NUL bytes (not
+// spaces) to fill its 32-byte field, per the El Torito specification.
+const elToritoBootSystemID = "EL TORITO SPECIFICATION"
+
+// EmulationType selects how the BIOS should present a boot image, recorded
+// in the Boot Catalog's Initial/Default Entry.
+type EmulationType byte
+
+const (
+	// NoEmulation loads the boot image and jumps to it directly, the mode
+	// used by isolinux, GRUB, and other modern boot loaders.
+	NoEmulation EmulationType = 0
+	// Floppy1200KBEmulation presents the boot image to the BIOS as a
+	// 1.2 MB floppy disk.
+	Floppy1200KBEmulation EmulationType = 1
+	// Floppy1440KBEmulation presents the boot image to the BIOS as a
+	// 1.44 MB floppy disk.
+	Floppy1440KBEmulation EmulationType = 2
+	// Floppy2880KBEmulation presents the boot image to the BIOS as a
+	// 2.88 MB floppy disk.
+	Floppy2880KBEmulation EmulationType = 3
+)
+
+// BootOptions configures the Boot Catalog entry written for a boot image
+// added via Builder.SetBootImage.
+type BootOptions struct {
+	// EmulationType selects no-emulation or floppy-emulation boot.
+	EmulationType EmulationType
+	// LoadSegment is the memory segment the BIOS loads the image at. Zero
+	// means the BIOS default (0x7C0).
+	LoadSegment uint16
+	// SectorCount is the number of 512-byte "virtual sectors" the BIOS
+	// loads before transferring control. Zero means "just large enough for
+	// the boot image", rounded up, in no-emulation mode, and 1 in a
+	// floppy-emulation mode; see SetBootImage.
+	SectorCount uint16
+}
+
+// bootImage is the boot image and catalog entry attached to a Builder via
+// SetBootImage.
+type bootImage struct {
+	r    io.Reader
+	size uint32
+	opts BootOptions
+
+	catalogLBA uint32
+	extentLBA  uint32
+	numSectors uint32
+}
+
+// SetBootImage makes the image bootable: a Boot Record Volume Descriptor
+// and a Boot Catalog are added to the volume descriptor set, and size
+// bytes are read from r into a reserved extent when Build is called.
+//
+// If opts.SectorCount is zero, it defaults to 1 for a floppy-emulation
+// mode, since the BIOS derives the real sector count from the emulated
+// media's geometry rather than this field; for no-emulation mode, it
+// defaults to enough 512-byte "virtual sectors" to cover size, rounded up.
+// SetBootImage returns an error if that default doesn't fit the Boot
+// Catalog entry's 16-bit SectorCount field; pass it explicitly to bypass
+// this for an unusually large no-emulation image the BIOS is expected to
+// load in its entirety regardless.
+func (b *Builder) SetBootImage(r io.Reader, size uint32, opts BootOptions) error {
+	if opts.SectorCount == 0 {
+		if opts.EmulationType == NoEmulation {
+			n := (uint64(size) + 511) / 512
+			if n > math.MaxUint16 {
+				return fmt.Errorf("iso9660: boot image is %d bytes, too large for the default El Torito sector count; set BootOptions.SectorCount explicitly", size)
+			}
+			opts.SectorCount = uint16(n)
+		} else {
+			opts.SectorCount = 1
+		}
+	}
+	b.boot = &bootImage{r: r, size: size, opts: opts}
+	return nil
+}
+
+// writeBootRecordVolumeDescriptor writes the Boot Record Volume Descriptor
+// (ECMA-119 type 0) that points El Torito-aware BIOSes at the Boot
+// Catalog.
+func writeBootRecordVolumeDescriptor(w *ISO9660Writer, expectedSector uint32, catalogLBA uint32) {
+	sw := w.NextSector()
+	if w.CurrentSector() != expectedSector {
+		panicf("internal error: unexpected boot record volume descriptor sector %d (expected %d)", w.CurrentSector(), expectedSector)
+	}
+
+	sw.WriteByte(0x00) // boot record
+	sw.WriteString(volumeDescriptorSetMagic)
+	sw.WriteString(elToritoBootSystemID)
+	sw.WriteZeros(32 - len(elToritoBootSystemID))
+	sw.WriteZeros(32) // boot identifier (unused)
+	sw.WriteLittleEndianDWord(catalogLBA)
+	sw.PadWithZeros()
+}
+
+// bootValidationEntry builds the Boot Catalog's 32-byte Validation Entry
+// for a BIOS (x86) platform, with its checksum field set so that the
+// 16-bit word sum of the entire entry is zero, as required by the El
+// Torito specification.
+func bootValidationEntry() []byte {
+	b := make([]byte, 32)
+	b[0] = 0x01 // header ID
+	b[1] = 0x00 // platform ID: 80x86
+	b[30] = 0x55
+	b[31] = 0xAA
+
+	var sum uint16
+	for i := 0; i < len(b); i += 2 {
+		sum += binary.LittleEndian.Uint16(b[i:])
+	}
+	binary.LittleEndian.PutUint16(b[28:], -sum)
+	return b
+}
+
+// bootInitialEntry builds the Boot Catalog's 32-byte Initial/Default Entry
+// describing the bootable, no-emulation-or-floppy-emulation image at
+// loadLBA.
+func bootInitialEntry(opts BootOptions, loadLBA uint32) []byte {
+	b := make([]byte, 32)
+	b[0] = 0x88 // bootable
+	b[1] = byte(opts.EmulationType)
+	binary.LittleEndian.PutUint16(b[2:], opts.LoadSegment)
+	binary.LittleEndian.PutUint16(b[6:], opts.SectorCount)
+	binary.LittleEndian.PutUint32(b[8:], loadLBA)
+	return b
+}
+
+// writeBootCatalog writes the Boot Catalog sector: a Validation Entry
+// followed by the Initial/Default Entry for boot.
+func writeBootCatalog(w *ISO9660Writer, boot *bootImage) {
+	sw := w.NextSector()
+	if w.CurrentSector() != boot.catalogLBA {
+		panicf("internal error: unexpected boot catalog sector %d (expected %d)", w.CurrentSector(), boot.catalogLBA)
+	}
+	sw.write(bootValidationEntry())
+	sw.write(bootInitialEntry(boot.opts, boot.extentLBA))
+	sw.PadWithZeros()
+}
+
+// writeBootImageData writes the boot image's raw contents to its reserved
+// extent.
+func writeBootImageData(w *ISO9660Writer, boot *bootImage) {
+	b := make([]byte, SectorSize)
+	total := uint32(0)
+	for total < boot.size {
+		n := boot.size - total
+		if n > SectorSize {
+			n = SectorSize
+		}
+		sw := w.NextSector()
+		if _, err := io.ReadFull(boot.r, b[:n]); err != nil {
+			panicf("could not read from boot image: %s", err)
+		}
+		sw.Write(b[:n])
+		total += n
+	}
+	if total == 0 {
+		w.NextSector()
+	}
+}
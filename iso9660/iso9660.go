@@ -0,0 +1,154 @@
+// Package iso9660 writes ISO9660 images. It is the library underlying the
+// iso9660wrap command: given a file, or a tree of files and directories, it
+// produces a minimal but valid ISO9660 image containing them, optionally
+// with a Joliet supplementary volume descriptor for long Unicode names.
+package iso9660
+
+import (
+	"io"
+	"time"
+)
+
+const volumeDescriptorSetMagic = "\x43\x44\x30\x30\x31\x01"
+
+const primaryVolumeSectorNum uint32 = 16
+
+// jolietEscapeSequence identifies the Joliet supplementary volume
+// descriptor's UCS-2 level 3 repertoire.
+const jolietEscapeSequence = "%/E"
+
+// WrapFile writes a single-file ISO9660 image containing the size bytes
+// read from src to dst. name is used as the file's identifier on the
+// image; it is uppercased and sanitized as described for Builder.Add, and
+// WrapFile returns an error only if name is empty.
+func WrapFile(dst io.Writer, src io.Reader, size uint64, name string) error {
+	b := NewBuilder()
+	if err := b.Add(name, src, size, Attributes{}); err != nil {
+		return err
+	}
+	return b.Build(dst)
+}
+
+// withPanicRecovery runs f, converting any panic carrying an error (as
+// raised by the write helpers below when the underlying io.Writer fails, or
+// when an internal invariant is violated) into a returned error.
+func withPanicRecovery(f func()) (err error) {
+	defer func() {
+		e := recover()
+		if e == nil {
+			return
+		}
+		var ok bool
+		err, ok = e.(error)
+		if !ok {
+			panic(e)
+		}
+	}()
+	f()
+	return nil
+}
+
+// volumeDescriptorParams holds the layout facts a volume descriptor needs
+// to reference its own (primary or Joliet) path table and directory
+// hierarchy.
+type volumeDescriptorParams struct {
+	totalSectors     uint32
+	pathTableBytes   uint32
+	pathTableSectors uint32
+	leSectorNum      uint32
+	rootLBA          uint32
+	rootBytes        uint32
+}
+
+func writePrimaryVolumeDescriptor(w *ISO9660Writer, expectedSector uint32, p volumeDescriptorParams) {
+	writeVolumeDescriptor(w, expectedSector, '\x01', "", p, ASCIIEncoding)
+}
+
+func writeSupplementaryVolumeDescriptor(w *ISO9660Writer, expectedSector uint32, p volumeDescriptorParams) {
+	writeVolumeDescriptor(w, expectedSector, '\x02', jolietEscapeSequence, p, UCS2Encoding)
+}
+
+func writeVolumeDescriptor(w *ISO9660Writer, expectedSector uint32, descriptorType byte, escapeSequence string, p volumeDescriptorParams, enc Encoding) {
+	now := time.Now()
+
+	sw := w.NextSector()
+	if w.CurrentSector() != expectedSector {
+		panicf("internal error: unexpected volume descriptor sector %d (expected %d)", w.CurrentSector(), expectedSector)
+	}
+
+	sw.WriteByte(descriptorType)
+	sw.WriteString(volumeDescriptorSetMagic)
+	sw.WriteByte('\x00')
+
+	sw.WritePaddedString("", 32)
+	sw.WritePaddedString("", 32) // volume identifier
+
+	sw.WriteZeros(8)
+	sw.WriteBothEndianDWord(p.totalSectors)
+	// Unused in the PVD (ECMA-119 8.4.5: shall be all (00) bytes); holds the
+	// escape sequence identifying the SVD's character set otherwise. Either
+	// way it's NUL-padded, not space-padded like a d-character/a-character
+	// string field.
+	sw.WriteString(escapeSequence)
+	sw.WriteZeros(32 - len(escapeSequence))
+
+	sw.WriteBothEndianWord(1) // volume set size
+	sw.WriteBothEndianWord(1) // volume sequence number
+	sw.WriteBothEndianWord(uint16(SectorSize))
+	sw.WriteBothEndianDWord(p.pathTableBytes)
+
+	beSectorNum := p.leSectorNum + p.pathTableSectors
+	sw.WriteLittleEndianDWord(p.leSectorNum)
+	sw.WriteLittleEndianDWord(0) // no secondary path tables
+	sw.WriteBigEndianDWord(beSectorNum)
+	sw.WriteBigEndianDWord(0) // no secondary path tables
+
+	WriteDirectoryRecord(sw, "\x00", p.rootLBA, p.rootBytes, enc, nil) // root directory
+
+	sw.WritePaddedString("", 128) // volume set identifier
+	sw.WritePaddedString("", 128) // publisher identifier
+	sw.WritePaddedString("", 128) // data preparer identifier
+	sw.WritePaddedString("", 128) // application identifier
+
+	sw.WritePaddedString("", 37) // copyright file identifier
+	sw.WritePaddedString("", 37) // abstract file identifier
+	sw.WritePaddedString("", 37) // bibliographical file identifier
+
+	sw.WriteDateTime(now)         // volume creation
+	sw.WriteDateTime(now)         // most recent modification
+	sw.WriteUnspecifiedDateTime() // expires
+	sw.WriteUnspecifiedDateTime() // is effective (?)
+
+	sw.WriteByte('\x01')
+	sw.WriteByte('\x00')
+
+	sw.PadWithZeros() // 512 (reserved for app) + 653 (zeros)
+}
+
+func writeVolumeDescriptorSetTerminator(w *ISO9660Writer, expectedSector uint32) {
+	sw := w.NextSector()
+	if w.CurrentSector() != expectedSector {
+		panicf("internal error: unexpected volume descriptor set terminator sector %d (expected %d)", w.CurrentSector(), expectedSector)
+	}
+
+	sw.WriteByte('\xFF')
+	sw.WriteString(volumeDescriptorSetMagic)
+
+	sw.PadWithZeros()
+}
+
+// packedSectorCount returns how many SectorSize-byte sectors are needed to
+// pack items of the given lengths back-to-back, without ever splitting a
+// single item across a sector boundary.
+func packedSectorCount(itemLens []int) uint32 {
+	sectors := uint32(1)
+	used := 0
+	for _, l := range itemLens {
+		if used+l > int(SectorSize) {
+			sectors++
+			used = 0
+		}
+		used += l
+	}
+	return sectors
+}
@@ -0,0 +1,521 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// vdRootDirRecordOffset and vdEscapeSequenceOffset are the fixed byte
+// offsets, within a primary or supplementary volume descriptor's sector,
+// of its embedded root directory record and (for a supplementary volume
+// descriptor) its escape sequence field, per ECMA-119 8.4/8.5.
+const (
+	vdRootDirRecordOffset  = 156
+	vdEscapeSequenceOffset = 88
+)
+
+// Reader reads an ISO9660 image written by this package (or, to the extent
+// its layout assumptions hold, by other ISO9660 writers). It prefers the
+// Joliet supplementary volume descriptor's directory hierarchy for names
+// when one is present, falling back to the primary hierarchy otherwise, and
+// always surfaces Rock Ridge POSIX attributes from the primary hierarchy,
+// since that's the only one Rock Ridge data is ever written to. A *Reader
+// implements fs.FS.
+type Reader struct {
+	ra   io.ReaderAt
+	root *entry
+}
+
+// entry is one parsed directory record, resolved against any Rock Ridge
+// data found for it. Directories additionally hold their children, sorted
+// by name.
+type entry struct {
+	name     string
+	isDir    bool
+	extents  []fileExtent
+	size     uint64
+	attrs    Attributes
+	children []*entry
+}
+
+func (e *entry) childNamed(name string) *entry {
+	for _, c := range e.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// NewReader parses ra's volume descriptor set and directory hierarchy.
+func NewReader(ra io.ReaderAt) (*Reader, error) {
+	var primaryLBA, primarySize uint32
+	var jolietLBA, jolietSize uint32
+	var havePrimary, haveJoliet bool
+
+	for sector := primaryVolumeSectorNum; ; sector++ {
+		buf := make([]byte, SectorSize)
+		if _, err := ra.ReadAt(buf, int64(sector)*int64(SectorSize)); err != nil {
+			return nil, fmt.Errorf("iso9660: reading volume descriptor at sector %d: %w", sector, err)
+		}
+		if string(buf[1:7]) != volumeDescriptorSetMagic {
+			return nil, fmt.Errorf("iso9660: sector %d does not hold a volume descriptor", sector)
+		}
+
+		switch buf[0] {
+		case 0xFF: // volume descriptor set terminator
+			if !havePrimary {
+				return nil, errors.New("iso9660: no primary volume descriptor found")
+			}
+			r := &Reader{ra: ra}
+			enc, lba, size := ASCIIEncoding, primaryLBA, primarySize
+			if haveJoliet {
+				enc, lba, size = UCS2Encoding, jolietLBA, jolietSize
+			}
+			root, err := r.readDirectory(lba, size, enc, primaryLBA, primarySize)
+			if err != nil {
+				return nil, err
+			}
+			r.root = root
+			return r, nil
+		case 0x01: // primary volume descriptor
+			rec, ok := parseDirectoryRecord(buf[vdRootDirRecordOffset:], ASCIIEncoding)
+			if !ok {
+				return nil, errors.New("iso9660: malformed root directory record in primary volume descriptor")
+			}
+			primaryLBA, primarySize = rec.extentLBA, rec.dataLength
+			havePrimary = true
+		case 0x02: // supplementary volume descriptor
+			esc := string(buf[vdEscapeSequenceOffset : vdEscapeSequenceOffset+len(jolietEscapeSequence)])
+			if esc != jolietEscapeSequence {
+				continue
+			}
+			rec, ok := parseDirectoryRecord(buf[vdRootDirRecordOffset:], UCS2Encoding)
+			if !ok {
+				return nil, errors.New("iso9660: malformed root directory record in supplementary volume descriptor")
+			}
+			jolietLBA, jolietSize = rec.extentLBA, rec.dataLength
+			haveJoliet = true
+		}
+	}
+}
+
+// rawRecord is one parsed, but not yet resolved, directory record.
+type rawRecord struct {
+	extentLBA  uint32
+	dataLength uint32
+	flags      byte
+	name       string
+	susp       []byte
+	recordLen  int
+}
+
+// parseDirectoryRecord parses the directory record at the start of b,
+// decoding its identifier per enc. It returns false if b starts with a
+// zero length byte, meaning the rest of the sector is padding.
+func parseDirectoryRecord(b []byte, enc Encoding) (rawRecord, bool) {
+	if len(b) == 0 || b[0] == 0 {
+		return rawRecord{}, false
+	}
+	recordLen := int(b[0])
+	if recordLen < 34 || recordLen > len(b) {
+		return rawRecord{}, false
+	}
+
+	extentLBA := binary.LittleEndian.Uint32(b[2:6])
+	dataLength := binary.LittleEndian.Uint32(b[10:14])
+	flags := b[25]
+
+	idLen := int(b[32])
+	idStart := 33
+	idEnd := idStart + idLen
+	if idEnd > recordLen {
+		return rawRecord{}, false
+	}
+
+	var name string
+	if idLen == 1 && (b[idStart] == 0 || b[idStart] == 1) {
+		name = string(b[idStart:idEnd])
+	} else {
+		name = decodeIdentifier(b[idStart:idEnd], enc)
+	}
+
+	suspStart := idEnd
+	if idLen%2 == 0 {
+		suspStart++
+	}
+	var susp []byte
+	if suspStart < recordLen {
+		susp = b[suspStart:recordLen]
+	}
+
+	return rawRecord{
+		extentLBA:  extentLBA,
+		dataLength: dataLength,
+		flags:      flags,
+		name:       name,
+		susp:       susp,
+		recordLen:  recordLen,
+	}, true
+}
+
+// decodeIdentifier is the inverse of identifierBytes.
+func decodeIdentifier(b []byte, enc Encoding) string {
+	if enc == UCS2Encoding {
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(b[i*2:])
+		}
+		return string(utf16.Decode(units))
+	}
+	return string(b)
+}
+
+// readSector reads the sector at lba.
+func (r *Reader) readSector(lba uint32) ([]byte, error) {
+	buf := make([]byte, SectorSize)
+	if _, err := r.ra.ReadAt(buf, int64(lba)*int64(SectorSize)); err != nil {
+		return nil, fmt.Errorf("iso9660: reading sector %d: %w", lba, err)
+	}
+	return buf, nil
+}
+
+// readBytesAt reads length bytes starting offset bytes into the extent at
+// lba; used to fetch Rock Ridge continuation areas (CE entries), which
+// aren't necessarily sector-aligned.
+func (r *Reader) readBytesAt(lba uint32, offset uint32, length uint32) ([]byte, error) {
+	buf := make([]byte, length)
+	pos := int64(lba)*int64(SectorSize) + int64(offset)
+	if _, err := r.ra.ReadAt(buf, pos); err != nil {
+		return nil, fmt.Errorf("iso9660: reading continuation area at sector %d: %w", lba, err)
+	}
+	return buf, nil
+}
+
+// readDirectoryRecords reads and parses every directory record in the
+// extent at extentLBA, dataLength bytes long.
+func (r *Reader) readDirectoryRecords(extentLBA uint32, dataLength uint32, enc Encoding) ([]rawRecord, error) {
+	numSectors := numSectorsForSize(dataLength)
+
+	var records []rawRecord
+	for s := uint32(0); s < numSectors; s++ {
+		sector, err := r.readSector(extentLBA + s)
+		if err != nil {
+			return nil, err
+		}
+		for pos := 0; pos < len(sector); {
+			rec, ok := parseDirectoryRecord(sector[pos:], enc)
+			if !ok {
+				break // the rest of the sector is padding
+			}
+			records = append(records, rec)
+			pos += rec.recordLen
+		}
+	}
+	return records, nil
+}
+
+// readDirectory parses the directory extent at nameLBA into an *entry,
+// recursing into any subdirectories. Rock Ridge data (and so POSIX
+// attributes and the NM alternate name) is only ever written to the
+// primary hierarchy's records, even when Joliet is also present, so
+// attrsLBA/attrsSize identify the same directory's extent in the primary
+// hierarchy; when nameEnc is already ASCIIEncoding, the two coincide.
+func (r *Reader) readDirectory(nameLBA uint32, nameSize uint32, nameEnc Encoding, attrsLBA uint32, attrsSize uint32) (*entry, error) {
+	nameRecords, err := r.readDirectoryRecords(nameLBA, nameSize, nameEnc)
+	if err != nil {
+		return nil, err
+	}
+	if len(nameRecords) < 2 {
+		return nil, fmt.Errorf("iso9660: directory extent %d is missing its \".\"/\"..\" entries", nameLBA)
+	}
+
+	attrsRecords := nameRecords
+	if attrsLBA != nameLBA || attrsSize != nameSize {
+		attrsRecords, err = r.readDirectoryRecords(attrsLBA, attrsSize, ASCIIEncoding)
+		if err != nil {
+			return nil, err
+		}
+		if len(attrsRecords) < 2 {
+			return nil, fmt.Errorf("iso9660: directory extent %d is missing its \".\"/\"..\" entries", attrsLBA)
+		}
+	}
+
+	self := &entry{isDir: true}
+
+	i, j := 2, 2
+	for i < len(nameRecords) {
+		rec := nameRecords[i]
+		var attrsRec rawRecord
+		if j < len(attrsRecords) {
+			attrsRec = attrsRecords[j]
+		}
+
+		if rec.flags&fileFlagDirectory != 0 {
+			child, err := r.readDirectory(rec.extentLBA, rec.dataLength, nameEnc, attrsRec.extentLBA, attrsRec.dataLength)
+			if err != nil {
+				return nil, err
+			}
+			attrs, altName, err := r.parseSystemUseArea(attrsRec.susp, Attributes{Mode: os.ModeDir | 0755})
+			if err != nil {
+				return nil, err
+			}
+			child.name = rec.name
+			if altName != "" && nameEnc == ASCIIEncoding {
+				child.name = altName
+			}
+			child.attrs = attrs
+			self.children = append(self.children, child)
+			i++
+			j++
+			continue
+		}
+
+		name := rec.name
+		var extents []fileExtent
+		var size uint64
+		for {
+			extents = append(extents, fileExtent{lba: rec.extentLBA, size: rec.dataLength})
+			size += uint64(rec.dataLength)
+			notFinal := rec.flags&fileFlagNotFinal != 0
+			i++
+			j++
+			if !notFinal {
+				break
+			}
+			if i >= len(nameRecords) || nameRecords[i].name != name {
+				return nil, fmt.Errorf("iso9660: truncated multi-extent file %q", name)
+			}
+			rec = nameRecords[i]
+			if j < len(attrsRecords) {
+				attrsRec = attrsRecords[j]
+			}
+		}
+
+		attrs, altName, err := r.parseSystemUseArea(attrsRec.susp, Attributes{Mode: 0644})
+		if err != nil {
+			return nil, err
+		}
+		if altName != "" && nameEnc == ASCIIEncoding {
+			name = altName
+		}
+		self.children = append(self.children, &entry{name: name, extents: extents, size: size, attrs: attrs})
+	}
+
+	sort.Slice(self.children, func(i, j int) bool { return self.children[i].name < self.children[j].name })
+	return self, nil
+}
+
+// tfFlagCreation is the RRIP "TF" entry's creation-timestamp flag (RRIP
+// 4.1.6); tfEntry never sets it (this package doesn't track a creation
+// time distinct from ModTime), but a record read from elsewhere may.
+const tfFlagCreation byte = 1 << 0
+
+// posixModeToFileMode is the inverse of posixMode.
+func posixModeToFileMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	if m&posixIFDIR != 0 {
+		mode |= os.ModeDir
+	}
+	return mode
+}
+
+// parseRockRidgeTime is the inverse of appendRockRidgeTime.
+func parseRockRidgeTime(b []byte) time.Time {
+	loc := time.FixedZone("", int(int8(b[6]))*15*60)
+	return time.Date(1900+int(b[0]), time.Month(b[1]), int(b[2]), int(b[3]), int(b[4]), int(b[5]), 0, loc)
+}
+
+// parseSystemUseArea parses a directory record's Rock Ridge system use
+// area, if any, starting from base (the default attributes to use for any
+// field no entry supplies), following CE entries into continuation areas
+// as needed. It returns the resolved attributes and the alternate (NM)
+// name, if present.
+func (r *Reader) parseSystemUseArea(susp []byte, base Attributes) (Attributes, string, error) {
+	attrs := base
+	var name string
+	for len(susp) >= 4 {
+		sig := string(susp[0:2])
+		length := int(susp[2])
+		if length < 4 || length > len(susp) {
+			break
+		}
+		payload := susp[4:length]
+
+		switch sig {
+		case "PX":
+			if len(payload) >= 28 {
+				attrs.Mode = posixModeToFileMode(binary.LittleEndian.Uint32(payload[0:4]))
+				attrs.UID = binary.LittleEndian.Uint32(payload[16:20])
+				attrs.GID = binary.LittleEndian.Uint32(payload[24:28])
+			}
+		case "TF":
+			if len(payload) >= 1 {
+				flags := payload[0]
+				data := payload[1:]
+				for _, f := range []struct {
+					bit byte
+					set func(time.Time)
+				}{
+					{tfFlagCreation, func(time.Time) {}},
+					{tfFlagModify, func(t time.Time) { attrs.ModTime = t }},
+					{tfFlagAccess, func(t time.Time) { attrs.AccessTime = t }},
+					{tfFlagAttributes, func(t time.Time) { attrs.ChangeTime = t }},
+				} {
+					if flags&f.bit == 0 {
+						continue
+					}
+					if len(data) < 7 {
+						break
+					}
+					f.set(parseRockRidgeTime(data[:7]))
+					data = data[7:]
+				}
+			}
+		case "NM":
+			if len(payload) >= 1 {
+				name += string(payload[1:])
+			}
+		case "CE":
+			if len(payload) >= 24 {
+				ceLBA := binary.LittleEndian.Uint32(payload[0:4])
+				ceOffset := binary.LittleEndian.Uint32(payload[8:12])
+				ceLength := binary.LittleEndian.Uint32(payload[16:20])
+				data, err := r.readBytesAt(ceLBA, ceOffset, ceLength)
+				if err != nil {
+					return Attributes{}, "", err
+				}
+				ceAttrs, ceName, err := r.parseSystemUseArea(data, attrs)
+				if err != nil {
+					return Attributes{}, "", err
+				}
+				attrs = ceAttrs
+				name += ceName
+			}
+		}
+
+		susp = susp[length:]
+	}
+	return attrs, name, nil
+}
+
+// fileInfo implements fs.FileInfo and fs.DirEntry for an entry.
+type fileInfo struct{ e *entry }
+
+func (fi fileInfo) Name() string               { return fi.e.name }
+func (fi fileInfo) Size() int64                { return int64(fi.e.size) }
+func (fi fileInfo) Mode() fs.FileMode          { return fi.e.attrs.Mode }
+func (fi fileInfo) ModTime() time.Time         { return fi.e.attrs.ModTime }
+func (fi fileInfo) IsDir() bool                { return fi.e.isDir }
+func (fi fileInfo) Sys() interface{}           { return fi.e.attrs }
+func (fi fileInfo) Type() fs.FileMode          { return fi.e.attrs.Mode.Type() }
+func (fi fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// dirFile implements fs.ReadDirFile for a directory entry.
+type dirFile struct {
+	e      *entry
+	offset int
+}
+
+func (d *dirFile) Stat() (fs.FileInfo, error) { return fileInfo{d.e}, nil }
+func (d *dirFile) Close() error               { return nil }
+
+func (d *dirFile) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.e.name, Err: errors.New("is a directory")}
+}
+
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.e.children[d.offset:]
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	} else if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	d.offset += len(rest)
+	entries := make([]fs.DirEntry, len(rest))
+	for i, c := range rest {
+		entries[i] = fileInfo{c}
+	}
+	return entries, nil
+}
+
+// file implements fs.File and io.ReadCloser for a regular file entry,
+// reading across its extents (more than one only for a multi-extent file).
+type file struct {
+	r      *Reader
+	e      *entry
+	extent int
+	off    int64
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return fileInfo{f.e}, nil }
+func (f *file) Close() error               { return nil }
+
+func (f *file) Read(p []byte) (int, error) {
+	for f.extent < len(f.e.extents) {
+		e := f.e.extents[f.extent]
+		if f.off >= int64(e.size) {
+			f.extent++
+			f.off = 0
+			continue
+		}
+		if remaining := int64(e.size) - f.off; int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+		n, err := f.r.ra.ReadAt(p, int64(e.lba)*int64(SectorSize)+f.off)
+		f.off += int64(n)
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		return n, nil
+	}
+	return 0, io.EOF
+}
+
+// Open opens the named file or directory for reading, implementing fs.FS.
+// name is a slash-separated path rooted at the image ("." for the root
+// directory itself), matched exactly against the names in the hierarchy
+// Reader chose (Joliet's original-case names or, without Joliet, each
+// record's Rock Ridge NM name where it has one and its uppercased primary
+// identifier otherwise). The returned fs.File also satisfies io.ReadCloser.
+func (r *Reader) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	e := r.root
+	if name != "." {
+		for _, part := range strings.Split(name, "/") {
+			if !e.isDir {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			child := e.childNamed(part)
+			if child == nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+			e = child
+		}
+	}
+
+	if e.isDir {
+		return &dirFile{e: e}, nil
+	}
+	return &file{r: r, e: e}, nil
+}
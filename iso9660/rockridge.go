@@ -0,0 +1,259 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// Attributes holds the POSIX metadata recorded for a file or directory via
+// Rock Ridge (SUSP) system use entries. The zero value means "unspecified":
+// a directory gets mode os.ModeDir|0755 and a file gets mode 0644, both
+// owned by uid/gid 0, with zero-value timestamps.
+type Attributes struct {
+	Mode       os.FileMode
+	UID        uint32
+	GID        uint32
+	ModTime    time.Time
+	AccessTime time.Time
+	ChangeTime time.Time
+}
+
+// rockRidgeMaxRecordLength is the largest value a directory record's own
+// length byte can hold; it bounds how much system use data can be embedded
+// directly in a record before a CE entry and a continuation area are
+// required.
+const rockRidgeMaxRecordLength = 255
+
+// suspEntry builds one System Use Sharing Protocol entry: a two-byte
+// signature, a length byte covering the whole entry, a version byte, and
+// the entry's payload.
+func suspEntry(sig string, version byte, payload []byte) []byte {
+	b := make([]byte, 4, 4+len(payload))
+	b[0], b[1] = sig[0], sig[1]
+	b[3] = version
+	b = append(b, payload...)
+	b[2] = byte(len(b))
+	return b
+}
+
+// Rock Ridge "RR" extension flags (RRIP 4.3.1), identifying which of the
+// optional entries below are present on a record.
+const (
+	rrFlagPX byte = 1 << 0
+	rrFlagNM byte = 1 << 3
+	rrFlagTF byte = 1 << 7
+)
+
+// spEntry is the SUSP "SP" indicator, required on the "." entry of the root
+// directory and nowhere else; it marks the start of the system use area.
+func spEntry() []byte {
+	return suspEntry("SP", 1, []byte{0xBE, 0xEF, 0})
+}
+
+func rrEntry(flags byte) []byte {
+	return suspEntry("RR", 1, []byte{flags})
+}
+
+// POSIX file type bits, as stored in the upper bits of a PX entry's
+// st_mode field.
+const (
+	posixIFDIR uint32 = 0040000
+	posixIFREG uint32 = 0100000
+)
+
+func posixMode(mode os.FileMode, isDir bool) uint32 {
+	perm := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		perm |= 0004000
+	}
+	if mode&os.ModeSetgid != 0 {
+		perm |= 0002000
+	}
+	if mode&os.ModeSticky != 0 {
+		perm |= 0001000
+	}
+	if isDir {
+		return posixIFDIR | perm
+	}
+	return posixIFREG | perm
+}
+
+func putBothEndianDWord(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b[0:4], v)
+	binary.BigEndian.PutUint32(b[4:8], v)
+}
+
+// pxEntry is the RRIP "PX" entry: POSIX file mode, link count, uid and gid.
+func pxEntry(attrs Attributes, isDir bool) []byte {
+	mode := attrs.Mode
+	if mode == 0 {
+		if isDir {
+			mode = os.ModeDir | 0755
+		} else {
+			mode = 0644
+		}
+	}
+
+	payload := make([]byte, 32)
+	putBothEndianDWord(payload[0:], posixMode(mode, isDir))
+	putBothEndianDWord(payload[8:], 1) // st_nlink
+	putBothEndianDWord(payload[16:], attrs.UID)
+	putBothEndianDWord(payload[24:], attrs.GID)
+	return suspEntry("PX", 1, payload)
+}
+
+// appendRockRidgeTime appends the 7-byte binary "Recording Date and Time"
+// form of t, the same format used by a plain directory record's header.
+func appendRockRidgeTime(b []byte, t time.Time) []byte {
+	_, offset := t.Zone()
+	return append(b,
+		byte(t.Year()-1900), byte(t.Month()), byte(t.Day()),
+		byte(t.Hour()), byte(t.Minute()), byte(t.Second()),
+		byte(offset/(15*60)))
+}
+
+// Rock Ridge "TF" entry timestamp flags (RRIP 4.1.6), identifying which of
+// the entry's timestamps follow its flags byte.
+const (
+	tfFlagModify     byte = 1 << 1
+	tfFlagAccess     byte = 1 << 2
+	tfFlagAttributes byte = 1 << 3
+)
+
+// tfEntry is the RRIP "TF" entry, recording whichever of attrs' modify,
+// access, and attribute change timestamps are set; a zero Time is omitted
+// rather than encoded, since the on-disk format can't represent it (it
+// would underflow the entry's single-byte year field).
+func tfEntry(attrs Attributes) []byte {
+	payload := []byte{0}
+	flags := byte(0)
+	for _, ts := range []struct {
+		flag byte
+		t    time.Time
+	}{
+		{tfFlagModify, attrs.ModTime},
+		{tfFlagAccess, attrs.AccessTime},
+		{tfFlagAttributes, attrs.ChangeTime},
+	} {
+		if ts.t.IsZero() {
+			continue
+		}
+		flags |= ts.flag
+		payload = appendRockRidgeTime(payload, ts.t)
+	}
+	payload[0] = flags
+	return suspEntry("TF", 1, payload)
+}
+
+// nmEntry is the RRIP "NM" entry, recording name's original spelling,
+// which may exceed the primary identifier's d-character / 8.3 limits.
+func nmEntry(name string) []byte {
+	return suspEntry("NM", 1, append([]byte{0}, name...))
+}
+
+// continuationBlock is a single Rock Ridge continuation area extent,
+// referenced by exactly one CE entry.
+type continuationBlock struct {
+	data       []byte
+	extentLBA  uint32
+	numSectors uint32
+}
+
+// ceEntry is the RRIP "CE" entry, pointing at a continuation area extent
+// holding the part of a record's system use area that didn't fit.
+func ceEntry(cb *continuationBlock) []byte {
+	payload := make([]byte, 24)
+	sw := func(off int, v uint32) {
+		binary.LittleEndian.PutUint32(payload[off:], v)
+		binary.BigEndian.PutUint32(payload[off+4:], v)
+	}
+	sw(0, cb.extentLBA)
+	sw(8, 0) // offset within the continuation area
+	sw(16, uint32(len(cb.data)))
+	return suspEntry("CE", 1, payload)
+}
+
+// rockRidgeLayout is the system use area for one directory record, and how
+// it is split between the record itself and a continuation area entry, if
+// the area doesn't fit within the record's 255-byte length limit.
+type rockRidgeLayout struct {
+	head []byte // SP? + RR + PX + TF; always kept in the record
+	nm   []byte // NM entry; moved to a continuation block if it doesn't fit
+	ce   *continuationBlock
+}
+
+// buildRockRidgeLayout assembles the system use area for a directory
+// record. root is true only for the root directory's own "." entry, which
+// carries the mandatory SP indicator; name is the original, unsanitized
+// name to record in an NM entry (empty for "." and ".." entries, which
+// don't need one).
+func buildRockRidgeLayout(attrs Attributes, isDir bool, root bool, name string) *rockRidgeLayout {
+	flags := rrFlagPX | rrFlagTF
+	var nm []byte
+	if name != "" {
+		flags |= rrFlagNM
+		nm = nmEntry(name)
+	}
+
+	var head []byte
+	if root {
+		head = append(head, spEntry()...)
+	}
+	head = append(head, rrEntry(flags)...)
+	head = append(head, pxEntry(attrs, isDir)...)
+	head = append(head, tfEntry(attrs)...)
+
+	return &rockRidgeLayout{head: head, nm: nm}
+}
+
+// inRecordLength returns how many bytes of l are embedded directly in the
+// directory record, accounting for whether a split has moved the NM entry
+// into a continuation area.
+func (l *rockRidgeLayout) inRecordLength() int {
+	n := len(l.head)
+	if l.ce != nil {
+		n += rockRidgeCELength
+	} else {
+		n += len(l.nm)
+	}
+	return n
+}
+
+// rockRidgeCELength is the on-disk length of a CE entry: a 4-byte SUSP
+// header plus three both-endian dwords (location, offset, and length of
+// the continuation area).
+const rockRidgeCELength = 28
+
+// split decides, given the identifier this record is for, whether l's NM
+// entry must be moved into a continuation area to keep the record within
+// its 255-byte length limit, appending the resulting block to *blocks.
+func (l *rockRidgeLayout) split(idLen int, blocks *[]*continuationBlock) {
+	overhead := 33 + idLen
+	if idLen%2 == 0 {
+		overhead++
+	}
+	total := overhead + len(l.head) + len(l.nm)
+	if total%2 != 0 {
+		total++ // the record as a whole is padded to an even length too
+	}
+	if len(l.nm) == 0 || total <= rockRidgeMaxRecordLength {
+		return
+	}
+	cb := &continuationBlock{data: l.nm}
+	*blocks = append(*blocks, cb)
+	l.ce = cb
+}
+
+// bytes returns the system use area to write into the directory record
+// itself: head, then either the NM entry or (if split moved it out) a CE
+// entry pointing at its continuation block.
+func (l *rockRidgeLayout) bytes() []byte {
+	b := append([]byte{}, l.head...)
+	if l.ce != nil {
+		b = append(b, ceEntry(l.ce)...)
+	} else {
+		b = append(b, l.nm...)
+	}
+	return b
+}
@@ -0,0 +1,291 @@
+package iso9660
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRoundTrip builds a small tree with nested directories, Rock Ridge
+// attributes, and names long enough to require Joliet/NM, writes it to an
+// in-memory image, and checks that a Reader sees the same tree back.
+func TestRoundTrip(t *testing.T) {
+	b := NewBuilder()
+
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	fileAttrs := Attributes{Mode: 0640, UID: 1000, GID: 1000, ModTime: modTime, AccessTime: modTime, ChangeTime: modTime}
+	dirAttrs := Attributes{Mode: os.ModeDir | 0750, UID: 1000, GID: 1000, ModTime: modTime, AccessTime: modTime, ChangeTime: modTime}
+
+	if err := b.SetDirAttributes("a/a-very-long-subdirectory-name", dirAttrs); err != nil {
+		t.Fatalf("SetDirAttributes: %s", err)
+	}
+	content := []byte("hello, rock ridge")
+	if err := b.Add("a/a-very-long-subdirectory-name/a-very-long-file-name.txt", bytes.NewReader(content), uint64(len(content)), fileAttrs); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	if err := b.Add("ROOT.TXT", bytes.NewReader([]byte("root")), 4, fileAttrs); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := b.Build(&buf); err != nil {
+		t.Fatalf("Build: %s", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+
+	const longPath = "a/a-very-long-subdirectory-name/a-very-long-file-name.txt"
+	fi, err := fs.Stat(r, longPath)
+	if err != nil {
+		t.Fatalf("Stat %s: %s", longPath, err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("long file mode = %o, want %o", fi.Mode().Perm(), 0640)
+	}
+	if got := fi.Sys().(Attributes); got.UID != 1000 || got.GID != 1000 {
+		t.Errorf("long file uid/gid = %d/%d, want 1000/1000", got.UID, got.GID)
+	}
+	if !fi.ModTime().Equal(modTime) {
+		t.Errorf("long file ModTime = %s, want %s", fi.ModTime(), modTime)
+	}
+
+	f, err := r.Open(longPath)
+	if err != nil {
+		t.Fatalf("Open %s: %s", longPath, err)
+	}
+	got, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("reading %s: %s", longPath, err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("long file content = %q, want %q", got, content)
+	}
+
+	subFi, err := fs.Stat(r, "a/a-very-long-subdirectory-name")
+	if err != nil {
+		t.Fatalf("Stat subdirectory: %s", err)
+	}
+	if !subFi.IsDir() {
+		t.Errorf("a/a-very-long-subdirectory-name is not a directory")
+	}
+	if subFi.Mode().Perm() != 0750 {
+		t.Errorf("subdirectory mode = %o, want %o", subFi.Mode().Perm(), 0750)
+	}
+
+	rootContent, err := fs.ReadFile(r, "ROOT.TXT")
+	if err != nil {
+		t.Fatalf("ReadFile ROOT.TXT: %s", err)
+	}
+	if string(rootContent) != "root" {
+		t.Errorf("ROOT.TXT content = %q, want %q", rootContent, "root")
+	}
+}
+
+// TestPrimaryVolumeDescriptorStructure checks the deterministic, fixed-offset
+// fields of the written primary volume descriptor and root directory record
+// against the golden ECMA-119 layout, then the Joliet supplementary volume
+// descriptor's escape sequence and the volume descriptor set terminator
+// that follow it. Timestamps aren't checked here, since
+// writeVolumeDescriptor stamps them from time.Now() and so aren't
+// reproducible between runs.
+func TestPrimaryVolumeDescriptorStructure(t *testing.T) {
+	b := NewBuilder()
+	if err := b.Add("FILE.TXT", bytes.NewReader([]byte("hi")), 2, Attributes{}); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := b.Build(&buf); err != nil {
+		t.Fatalf("Build: %s", err)
+	}
+	image := buf.Bytes()
+
+	pvd := image[primaryVolumeSectorNum*SectorSize : (primaryVolumeSectorNum+1)*SectorSize]
+	if pvd[0] != 0x01 {
+		t.Errorf("PVD type byte = %#x, want 0x01", pvd[0])
+	}
+	if string(pvd[1:6]) != "CD001" {
+		t.Errorf("PVD magic = %q, want %q", pvd[1:6], "CD001")
+	}
+	if pvd[6] != 0x01 {
+		t.Errorf("PVD version byte = %#x, want 0x01", pvd[6])
+	}
+	if lbs := binary.LittleEndian.Uint16(pvd[128:130]); lbs != uint16(SectorSize) {
+		t.Errorf("PVD logical block size = %d, want %d", lbs, SectorSize)
+	}
+
+	rec, ok := parseDirectoryRecord(pvd[vdRootDirRecordOffset:], ASCIIEncoding)
+	if !ok {
+		t.Fatalf("could not parse root directory record in PVD")
+	}
+	if rec.name != "\x00" {
+		t.Errorf("root directory record identifier = %q, want %q", rec.name, "\x00")
+	}
+	if rec.flags&fileFlagDirectory == 0 {
+		t.Errorf("root directory record flags = %#x, missing directory bit", rec.flags)
+	}
+
+	// ECMA-119 8.4.5: the PVD's escape-sequence-field position is unused and
+	// shall be all (00) bytes, not space-padded.
+	if unused := pvd[vdEscapeSequenceOffset : vdEscapeSequenceOffset+32]; !bytes.Equal(unused, make([]byte, 32)) {
+		t.Errorf("PVD unused field at offset %d = %x, want all zero", vdEscapeSequenceOffset, unused)
+	}
+
+	svd := image[(primaryVolumeSectorNum+1)*SectorSize : (primaryVolumeSectorNum+2)*SectorSize]
+	wantEsc := append([]byte(jolietEscapeSequence), make([]byte, 32-len(jolietEscapeSequence))...)
+	if esc := svd[vdEscapeSequenceOffset : vdEscapeSequenceOffset+32]; !bytes.Equal(esc, wantEsc) {
+		t.Errorf("SVD escape sequence field = %x, want %x", esc, wantEsc)
+	}
+
+	terminatorSector := image[(primaryVolumeSectorNum+2)*SectorSize : (primaryVolumeSectorNum+3)*SectorSize]
+	if terminatorSector[0] != 0xFF || string(terminatorSector[1:6]) != "CD001" {
+		t.Errorf("volume descriptor set terminator missing at sector %d", primaryVolumeSectorNum+2)
+	}
+}
+
+// TestTFEntryOmitsZeroTimestamps is a regression test: a zero-value
+// Attributes timestamp (as left by, e.g., WrapFile's root directory, or an
+// intermediate directory created by Add without SetDirAttributes) must be
+// omitted from the TF entry rather than encoded, since the on-disk format
+// can't represent year 1.
+func TestTFEntryOmitsZeroTimestamps(t *testing.T) {
+	entry := tfEntry(Attributes{})
+	flags := entry[4]
+	if flags != 0 {
+		t.Errorf("TF flags = %#x for all-zero Attributes, want 0", flags)
+	}
+	if len(entry) != 5 {
+		t.Errorf("TF entry length = %d for all-zero Attributes, want 5 (no timestamps)", len(entry))
+	}
+
+	modTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	entry = tfEntry(Attributes{ModTime: modTime})
+	flags = entry[4]
+	if flags != tfFlagModify {
+		t.Errorf("TF flags = %#x with only ModTime set, want %#x", flags, tfFlagModify)
+	}
+	if len(entry) != 5+7 {
+		t.Errorf("TF entry length = %d with only ModTime set, want %d", len(entry), 5+7)
+	}
+}
+
+// TestSelfDirectoryRecordHasNoName is a regression test: a subdirectory's
+// own "." self-record must not carry an NM entry naming the directory,
+// since RRIP forbids NM on "." and ".." records; only the parent's record
+// referencing the subdirectory should have one.
+func TestSelfDirectoryRecordHasNoName(t *testing.T) {
+	b := NewBuilder()
+	if err := b.Add("sub/file.txt", bytes.NewReader([]byte("x")), 1, Attributes{}); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+
+	dirs := layOutDirectoryHierarchy(b.root)
+	files := collectFiles(dirs)
+	var ceBlocks []*continuationBlock
+	computeRockRidgeLayouts(dirs, files, &ceBlocks)
+
+	var sub *dirNode
+	for _, d := range dirs {
+		if d.name == "SUB" {
+			sub = d
+		}
+	}
+	if sub == nil {
+		t.Fatalf("did not find the SUB directory among %d laid-out directories", len(dirs))
+	}
+
+	if bytes.Contains(sub.rrSelf.bytes(), []byte("NM")) {
+		t.Errorf("SUB's own self-record SUSP unexpectedly contains an NM entry: %x", sub.rrSelf.bytes())
+	}
+	if !bytes.Contains(sub.rr.bytes(), []byte("NM")) {
+		t.Errorf("SUB's parent-referenced record SUSP is missing its NM entry: %x", sub.rr.bytes())
+	}
+}
+
+// TestDirectoryRecordsAreEvenLength is a regression test: ECMA-119 9.1.1
+// requires every directory record to end on an even byte boundary, but
+// nothing previously accounted for a Rock Ridge system use area whose
+// length made the record's otherwise-even total odd. Names of varying
+// parity are used here since the identifier field itself is also padded
+// to keep the header even, and only the SUSP area can still leave the
+// whole record odd.
+func TestDirectoryRecordsAreEvenLength(t *testing.T) {
+	b := NewBuilder()
+	names := []string{"A.TXT", "AB.TXT", "ABC.TXT", "ABCD.TXT"}
+	for _, name := range names {
+		if err := b.Add(name, bytes.NewReader([]byte("x")), 1, Attributes{Mode: 0644}); err != nil {
+			t.Fatalf("Add %s: %s", name, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := b.Build(&buf); err != nil {
+		t.Fatalf("Build: %s", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+
+	pvd := buf.Bytes()[primaryVolumeSectorNum*SectorSize : (primaryVolumeSectorNum+1)*SectorSize]
+	rootRec, ok := parseDirectoryRecord(pvd[vdRootDirRecordOffset:], ASCIIEncoding)
+	if !ok {
+		t.Fatalf("could not parse root directory record in PVD")
+	}
+
+	records, err := r.readDirectoryRecords(rootRec.extentLBA, rootRec.dataLength, ASCIIEncoding)
+	if err != nil {
+		t.Fatalf("readDirectoryRecords: %s", err)
+	}
+	if len(records) != 2+len(names) {
+		t.Fatalf("got %d directory records, want %d", len(records), 2+len(names))
+	}
+	for _, rec := range records {
+		if rec.recordLen%2 != 0 {
+			t.Errorf("directory record %q has odd length %d", rec.name, rec.recordLen)
+		}
+	}
+}
+
+// TestAddRejectsDirectoryNameCollision is a regression test: two distinct
+// original directory names that sanitize to the same ISO9660 identifier
+// (e.g. "My-Dir" and "my_dir", both "MY_DIR") must not be silently merged
+// into one directory, dropping one side's Joliet/Rock Ridge name.
+func TestAddRejectsDirectoryNameCollision(t *testing.T) {
+	b := NewBuilder()
+	if err := b.Add("My-Dir/file.txt", bytes.NewReader([]byte("x")), 1, Attributes{}); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	err := b.Add("my_dir/other.txt", bytes.NewReader([]byte("y")), 1, Attributes{})
+	if err == nil {
+		t.Fatalf("Add with a colliding sanitized directory name unexpectedly succeeded")
+	}
+}
+
+// TestAddReusesSameDirectory checks that mkdirAll still reuses a directory
+// across multiple Add calls for the same original path component, rather
+// than treating every call as a potential collision.
+func TestAddReusesSameDirectory(t *testing.T) {
+	b := NewBuilder()
+	if err := b.Add("dir/a.txt", bytes.NewReader([]byte("x")), 1, Attributes{}); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	if err := b.Add("dir/b.txt", bytes.NewReader([]byte("y")), 1, Attributes{}); err != nil {
+		t.Fatalf("Add: %s", err)
+	}
+	if len(b.root.dirs) != 1 {
+		t.Fatalf("got %d top-level directories, want 1", len(b.root.dirs))
+	}
+	if len(b.root.dirs[0].files) != 2 {
+		t.Fatalf("got %d files in dir, want 2", len(b.root.dirs[0].files))
+	}
+}
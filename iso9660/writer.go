@@ -0,0 +1,325 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf16"
+)
+
+// SectorSize is the size, in bytes, of a single ISO9660 logical sector.
+const SectorSize uint32 = 2048
+
+func panicf(format string, v ...interface{}) {
+	panic(fmt.Errorf(format, v...))
+}
+
+// ISO9660Writer sequences a stream of fixed-size sectors onto an underlying
+// io.Writer. Callers obtain a *SectorWriter for the next sector via
+// NextSector, write exactly that sector's contents to it, and repeat; any
+// unwritten tail of a sector is zero-padded automatically when the writer
+// moves on to the next one.
+type ISO9660Writer struct {
+	w             io.Writer
+	currentSector uint32
+	started       bool
+	cur           *SectorWriter
+}
+
+// systemAreaSectors is the number of sectors reserved for the system area
+// at the start of every ISO9660 image; iso9660wrap always leaves it zeroed.
+const systemAreaSectors = 16
+
+// NewISO9660Writer zero-fills the system area and returns a writer whose
+// first call to NextSector produces the primary volume descriptor's
+// sector.
+func NewISO9660Writer(w io.Writer) *ISO9660Writer {
+	zeros := make([]byte, systemAreaSectors*SectorSize)
+	if _, err := w.Write(zeros); err != nil {
+		panic(err)
+	}
+	return &ISO9660Writer{w: w, currentSector: systemAreaSectors - 1, started: true}
+}
+
+// CurrentSector returns the sector number of the SectorWriter most recently
+// returned by NextSector.
+func (w *ISO9660Writer) CurrentSector() uint32 {
+	return w.currentSector
+}
+
+// NextSector pads out any unwritten tail of the current sector with zeros
+// and returns a SectorWriter for the next one.
+func (w *ISO9660Writer) NextSector() *SectorWriter {
+	if w.cur != nil {
+		w.cur.PadWithZeros()
+	}
+	if w.started {
+		w.currentSector++
+	} else {
+		w.started = true
+	}
+	w.cur = &SectorWriter{w: w}
+	return w.cur
+}
+
+// Finish pads out the tail of the last sector written. It must be called
+// after the last call to NextSector.
+func (w *ISO9660Writer) Finish() {
+	if w.cur != nil {
+		w.cur.PadWithZeros()
+	}
+}
+
+// SectorWriter writes the contents of a single sector. Writing more than
+// SectorSize bytes to a SectorWriter is a bug in the caller and causes a
+// panic, consistent with the rest of this package's error handling.
+type SectorWriter struct {
+	w *ISO9660Writer
+	n uint32
+}
+
+func (sw *SectorWriter) write(b []byte) {
+	if sw.n+uint32(len(b)) > SectorSize {
+		panicf("internal error: sector %d overflowed (attempted to write %d bytes at offset %d)",
+			sw.w.currentSector, len(b), sw.n)
+	}
+	_, err := sw.w.w.Write(b)
+	if err != nil {
+		panic(err)
+	}
+	sw.n += uint32(len(b))
+}
+
+// Write writes raw bytes to the sector, e.g. file data.
+func (sw *SectorWriter) Write(b []byte) (int, error) {
+	sw.write(b)
+	return len(b), nil
+}
+
+// WriteByte writes a single byte.
+func (sw *SectorWriter) WriteByte(b byte) error {
+	sw.write([]byte{b})
+	return nil
+}
+
+// WriteString writes s verbatim, with no padding.
+func (sw *SectorWriter) WriteString(s string) {
+	sw.write([]byte(s))
+}
+
+// WritePaddedString writes s, truncated or padded with spaces to exactly n
+// bytes, per the ISO9660 "d-characters"/"a-characters" string convention.
+func (sw *SectorWriter) WritePaddedString(s string, n int) {
+	if len(s) > n {
+		s = s[:n]
+	}
+	sw.write([]byte(s))
+	if pad := n - len(s); pad > 0 {
+		padding := make([]byte, pad)
+		for i := range padding {
+			padding[i] = ' '
+		}
+		sw.write(padding)
+	}
+}
+
+// WriteZeros writes n zero bytes.
+func (sw *SectorWriter) WriteZeros(n int) {
+	if n <= 0 {
+		return
+	}
+	sw.write(make([]byte, n))
+}
+
+// PadWithZeros zero-fills the remainder of the sector.
+func (sw *SectorWriter) PadWithZeros() {
+	sw.WriteZeros(int(SectorSize - sw.n))
+}
+
+// remaining returns the number of bytes still available in the sector.
+func (sw *SectorWriter) remaining() uint32 {
+	return SectorSize - sw.n
+}
+
+// WriteWord writes a 16-bit value in the given byte order.
+func (sw *SectorWriter) WriteWord(bo binary.ByteOrder, v uint16) {
+	var b [2]byte
+	bo.PutUint16(b[:], v)
+	sw.write(b[:])
+}
+
+// WriteDWord writes a 32-bit value in the given byte order.
+func (sw *SectorWriter) WriteDWord(bo binary.ByteOrder, v uint32) {
+	var b [4]byte
+	bo.PutUint32(b[:], v)
+	sw.write(b[:])
+}
+
+// WriteLittleEndianDWord writes a 32-bit little-endian value.
+func (sw *SectorWriter) WriteLittleEndianDWord(v uint32) {
+	sw.WriteDWord(binary.LittleEndian, v)
+}
+
+// WriteBigEndianDWord writes a 32-bit big-endian value.
+func (sw *SectorWriter) WriteBigEndianDWord(v uint32) {
+	sw.WriteDWord(binary.BigEndian, v)
+}
+
+// WriteBothEndianWord writes a 16-bit "both-endian" field: the value
+// encoded little-endian followed by the same value encoded big-endian, as
+// required by several ISO9660 volume descriptor fields.
+func (sw *SectorWriter) WriteBothEndianWord(v uint16) {
+	sw.WriteWord(binary.LittleEndian, v)
+	sw.WriteWord(binary.BigEndian, v)
+}
+
+// WriteBothEndianDWord writes a 32-bit "both-endian" field.
+func (sw *SectorWriter) WriteBothEndianDWord(v uint32) {
+	sw.WriteDWord(binary.LittleEndian, v)
+	sw.WriteDWord(binary.BigEndian, v)
+}
+
+// WriteDateTime writes the 17-byte "Date and Time Format" used by the
+// primary volume descriptor.
+func (sw *SectorWriter) WriteDateTime(t time.Time) {
+	s := fmt.Sprintf("%04d%02d%02d%02d%02d%02d%02d",
+		t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/10000000)
+	sw.write([]byte(s))
+	_, offset := t.Zone()
+	sw.WriteByte(byte(offset / (15 * 60)))
+}
+
+// WriteUnspecifiedDateTime writes the all-zero 17-byte date/time value used
+// to mean "not specified".
+func (sw *SectorWriter) WriteUnspecifiedDateTime() {
+	sw.write([]byte("0000000000000000"))
+	sw.WriteByte(0)
+}
+
+// writeRecordingDateTime writes the 7-byte binary "Recording Date and Time"
+// format embedded in every directory record.
+func (sw *SectorWriter) writeRecordingDateTime(t time.Time) {
+	sw.WriteByte(byte(t.Year() - 1900))
+	sw.WriteByte(byte(t.Month()))
+	sw.WriteByte(byte(t.Day()))
+	sw.WriteByte(byte(t.Hour()))
+	sw.WriteByte(byte(t.Minute()))
+	sw.WriteByte(byte(t.Second()))
+	_, offset := t.Zone()
+	sw.WriteByte(byte(offset / (15 * 60)))
+}
+
+// Directory record file flags (ECMA-119 9.1.6).
+const (
+	fileFlagDirectory byte = 1 << 1
+	// fileFlagNotFinal marks a directory record as one of the non-final
+	// extents of a file split across multiple records (ECMA-119 6.4.3),
+	// e.g. because it is too large for a single 32-bit extent length.
+	fileFlagNotFinal byte = 1 << 7
+)
+
+// Encoding selects how a directory record's file identifier is serialized.
+// ASCIIEncoding is used by the primary volume descriptor's directory
+// hierarchy; UCS2Encoding is used by the Joliet supplementary volume
+// descriptor's parallel hierarchy.
+type Encoding int
+
+const (
+	ASCIIEncoding Encoding = iota
+	UCS2Encoding
+)
+
+// identifierBytes encodes identifier per enc. The special single-byte
+// self/parent identifiers ("\x00" and "\x01") are always written as a
+// single raw byte, regardless of encoding.
+func identifierBytes(identifier string, enc Encoding) []byte {
+	if len(identifier) == 1 && (identifier[0] == 0 || identifier[0] == 1) {
+		return []byte(identifier)
+	}
+	if enc == UCS2Encoding {
+		units := utf16.Encode([]rune(identifier))
+		b := make([]byte, len(units)*2)
+		for i, u := range units {
+			binary.BigEndian.PutUint16(b[i*2:], u)
+		}
+		return b
+	}
+	return []byte(identifier)
+}
+
+// RecordLength returns the number of bytes a directory record for
+// identifier, encoded per enc, occupies. susp is the record's Rock Ridge
+// system use area, if any, and may be nil.
+func RecordLength(identifier string, enc Encoding, susp []byte) int {
+	n := len(identifierBytes(identifier, enc))
+	recordLen := 33 + n
+	if n%2 == 0 {
+		recordLen++
+	}
+	recordLen += len(susp)
+	if recordLen%2 != 0 {
+		recordLen++ // ECMA-119 9.1.1: every directory record ends on an even boundary
+	}
+	return recordLen
+}
+
+// writeDirectoryRecordHeader writes a directory record, common to both
+// plain directory records ("." and "..") and file record headers, with
+// susp (if non-nil) appended as its Rock Ridge system use area.
+func writeDirectoryRecordHeader(sw *SectorWriter, identifier string, extentLBA uint32, dataLength uint32, flags byte, enc Encoding, susp []byte) {
+	idBytes := identifierBytes(identifier, enc)
+	recordLen := 33 + len(idBytes)
+	if len(idBytes)%2 == 0 {
+		recordLen++
+	}
+	recordLen += len(susp)
+	pad := recordLen%2 != 0 // ECMA-119 9.1.1: every directory record ends on an even boundary
+	if pad {
+		recordLen++
+	}
+	if recordLen > 255 {
+		panicf("internal error: directory record for %q overflowed (length %d)", identifier, recordLen)
+	}
+
+	sw.WriteByte(byte(recordLen))
+	sw.WriteByte(0) // extended attribute record length
+	sw.WriteBothEndianDWord(extentLBA)
+	sw.WriteBothEndianDWord(dataLength)
+	sw.writeRecordingDateTime(time.Now())
+	sw.WriteByte(flags)
+	sw.WriteByte(0)           // file unit size (not interleaved)
+	sw.WriteByte(0)           // interleave gap size (not interleaved)
+	sw.WriteBothEndianWord(1) // volume sequence number
+	sw.WriteByte(byte(len(idBytes)))
+	sw.write(idBytes)
+	if len(idBytes)%2 == 0 {
+		sw.WriteByte(0) // padding field
+	}
+	if len(susp) > 0 {
+		sw.write(susp)
+	}
+	if pad {
+		sw.WriteByte(0)
+	}
+}
+
+// WriteDirectoryRecord writes a directory record for identifier (which may
+// be "\x00" for ".", "\x01" for "..", or a real subdirectory name) whose
+// extent, dataLength bytes long, is located at extentLBA. susp is the
+// record's Rock Ridge system use area, if any, and may be nil.
+func WriteDirectoryRecord(sw *SectorWriter, identifier string, extentLBA uint32, dataLength uint32, enc Encoding, susp []byte) {
+	writeDirectoryRecordHeader(sw, identifier, extentLBA, dataLength, fileFlagDirectory, enc, susp)
+}
+
+// WriteFileRecordHeader writes a directory record for a plain file named
+// name, occupying size bytes starting at extentLBA. notFinal marks this as
+// a non-final extent of a file whose data spans multiple directory records.
+// susp is the record's Rock Ridge system use area, if any, and may be nil.
+func WriteFileRecordHeader(sw *SectorWriter, name string, extentLBA uint32, size uint32, notFinal bool, enc Encoding, susp []byte) {
+	var flags byte
+	if notFinal {
+		flags = fileFlagNotFinal
+	}
+	writeDirectoryRecordHeader(sw, name, extentLBA, size, flags, enc, susp)
+}